@@ -0,0 +1,47 @@
+package sse
+
+import "context"
+
+// BrokerEventKind identifies what a BrokerEvent carries.
+type BrokerEventKind int
+
+const (
+	// BrokerEventMessage carries a Message to be broadcast to local clients.
+	BrokerEventMessage BrokerEventKind = iota
+	// BrokerEventChannelClosed announces that a channel was explicitly
+	// closed on the originating instance and should be closed locally too.
+	BrokerEventChannelClosed
+)
+
+// BrokerEvent is delivered by a Broker to every subscribed instance.
+type BrokerEvent struct {
+	Kind    BrokerEventKind
+	Channel string
+	Message *Message
+	// Instance identifies which Server instance published this event, so a
+	// receiving instance can tell its own lifecycle events apart from ones
+	// that originated elsewhere and avoid re-announcing them.
+	Instance string
+}
+
+// Broker lets multiple go-sse instances, e.g. behind a load balancer, fan a
+// message out to every client connected to any of them. Set it via
+// Options.BrokerBackend; without one, a Server only delivers messages to
+// clients connected to the same process.
+type Broker interface {
+	// Publish sends message to channel, to be delivered to every instance
+	// subscribed via Subscribe, including the publishing instance itself.
+	// instanceID identifies the publishing Server and is forwarded as
+	// BrokerEvent.Instance. ctx carries the cancellation of the
+	// SendMessageContext call that triggered this publish, so a slow
+	// implementation (e.g. a network round trip) can give up instead of
+	// blocking past its caller's deadline.
+	Publish(ctx context.Context, channel string, message *Message, instanceID string) error
+
+	// Subscribe returns a channel of BrokerEvent delivered to this instance
+	// until ctx is done.
+	Subscribe(ctx context.Context) (<-chan BrokerEvent, error)
+
+	// CloseChannel announces that channel has been closed on instanceID.
+	CloseChannel(channel string, instanceID string) error
+}
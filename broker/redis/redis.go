@@ -0,0 +1,116 @@
+// Package redis provides a sse.Broker backed by Redis Pub/Sub, letting
+// multiple go-sse instances behind a load balancer fan a message out to
+// every client connected to any of them.
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	goredis "github.com/go-redis/redis/v8"
+
+	sse "github.com/Haraj-backend/go-sse"
+)
+
+const channelPrefix = "go-sse:"
+
+// Broker is a sse.Broker implementation backed by Redis Pub/Sub.
+type Broker struct {
+	client *goredis.Client
+}
+
+// New creates a Broker that publishes and subscribes through client.
+func New(client *goredis.Client) *Broker {
+	return &Broker{client: client}
+}
+
+// wireEvent is the JSON payload published to Redis.
+type wireEvent struct {
+	Kind     int    `json:"kind"`
+	Channel  string `json:"channel"`
+	ID       string `json:"id,omitempty"`
+	Event    string `json:"event,omitempty"`
+	Data     string `json:"data,omitempty"`
+	Instance string `json:"instance"`
+}
+
+// Publish implements sse.Broker.
+func (b *Broker) Publish(ctx context.Context, channel string, message *sse.Message, instanceID string) error {
+	return b.publish(ctx, wireEvent{
+		Kind:     int(sse.BrokerEventMessage),
+		Channel:  channel,
+		ID:       message.ID(),
+		Event:    message.Event(),
+		Data:     message.Data(),
+		Instance: instanceID,
+	})
+}
+
+// CloseChannel implements sse.Broker.
+func (b *Broker) CloseChannel(channel string, instanceID string) error {
+	return b.publish(context.Background(), wireEvent{
+		Kind:     int(sse.BrokerEventChannelClosed),
+		Channel:  channel,
+		Instance: instanceID,
+	})
+}
+
+func (b *Broker) publish(ctx context.Context, event wireEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshaling broker event: %w", err)
+	}
+
+	return b.client.Publish(ctx, channelPrefix+event.Channel, payload).Err()
+}
+
+// Subscribe implements sse.Broker.
+func (b *Broker) Subscribe(ctx context.Context) (<-chan sse.BrokerEvent, error) {
+	pubsub := b.client.PSubscribe(ctx, channelPrefix+"*")
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return nil, fmt.Errorf("subscribing to redis: %w", err)
+	}
+
+	events := make(chan sse.BrokerEvent, 256)
+
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		msgs := pubsub.Channel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+
+				var wire wireEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &wire); err != nil {
+					continue
+				}
+
+				event := sse.BrokerEvent{
+					Kind:     sse.BrokerEventKind(wire.Kind),
+					Channel:  wire.Channel,
+					Instance: wire.Instance,
+				}
+				if event.Kind == sse.BrokerEventMessage {
+					event.Message = sse.NewMessage(wire.ID, wire.Event, wire.Data)
+				}
+
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
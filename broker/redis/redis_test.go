@@ -0,0 +1,131 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context/ctxhttp"
+
+	sse "github.com/Haraj-backend/go-sse"
+)
+
+func newTestClient(t *testing.T, addr string) *goredis.Client {
+	t.Helper()
+	return goredis.NewClient(&goredis.Options{Addr: addr})
+}
+
+// TestBrokerCrossInstanceFanOut verifies the whole point of this package:
+// two independent go-sse instances sharing only a Redis deployment still
+// deliver a message published on one to a client connected to the other.
+func TestBrokerCrossInstanceFanOut(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	srvA := sse.NewServer(&sse.Options{BrokerBackend: New(newTestClient(t, mr.Addr()))})
+	defer srvA.Shutdown()
+
+	srvB := sse.NewServer(&sse.Options{BrokerBackend: New(newTestClient(t, mr.Addr()))})
+	defer srvB.Shutdown()
+
+	srvtestB := httptest.NewServer(srvB)
+	defer srvtestB.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := ctxhttp.Get(ctx, http.DefaultClient, srvtestB.URL+"/news")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			if len(line) > len("data: ") {
+				received <- line[len("data: "):]
+				return
+			}
+		}
+	}()
+
+	// give instance B's HTTP handler a moment to register before publishing.
+	require.Eventually(t, func() bool { return srvB.HasChannel("/news") }, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, srvA.SendMessage("/news", sse.SimpleMessage("hello from A")))
+
+	select {
+	case data := <-received:
+		assert.Equal(t, "hello from A", data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message published on instance A never reached instance B")
+	}
+}
+
+// TestBrokerCloseChannelPropagates verifies that closing a channel on one
+// instance closes it on another instance sharing the same broker.
+func TestBrokerCloseChannelPropagates(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	srvA := sse.NewServer(&sse.Options{BrokerBackend: New(newTestClient(t, mr.Addr()))})
+	defer srvA.Shutdown()
+
+	srvB := sse.NewServer(&sse.Options{BrokerBackend: New(newTestClient(t, mr.Addr()))})
+	defer srvB.Shutdown()
+
+	require.NoError(t, srvB.SendMessage("to-close", sse.SimpleMessage("seed")))
+	require.Eventually(t, func() bool { return srvB.HasChannel("to-close") }, 2*time.Second, 10*time.Millisecond)
+
+	srvA.CloseChannel("to-close")
+
+	assert.Eventually(t, func() bool {
+		return !srvB.HasChannel("to-close")
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestBrokerPublishRoundTrip(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	broker := New(newTestClient(t, mr.Addr()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := broker.Subscribe(ctx)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, broker.Publish(
+			ctx,
+			"room-1",
+			sse.NewMessage(fmt.Sprintf("%d", i), "", "payload"),
+			"instance-a",
+		))
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-events:
+			assert.Equal(t, sse.BrokerEventMessage, event.Kind)
+			assert.Equal(t, "room-1", event.Channel)
+			assert.Equal(t, "instance-a", event.Instance)
+			assert.Equal(t, fmt.Sprintf("%d", i), event.Message.ID())
+		case <-time.After(2 * time.Second):
+			t.Fatalf("did not receive event %d in time", i)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package sse
+
+import (
+	"context"
+	"sync"
+)
+
+// LocalBroker is an in-memory Broker. It fans events out to every Subscribe
+// call within the same process, which is the same behavior a Server gets by
+// default without any Broker configured; it exists mainly as a reference
+// implementation and for exercising the Broker plumbing in tests without a
+// real dependency like Redis.
+type LocalBroker struct {
+	mu   sync.RWMutex
+	subs map[chan BrokerEvent]bool
+}
+
+// NewLocalBroker creates an in-memory Broker.
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{
+		subs: make(map[chan BrokerEvent]bool),
+	}
+}
+
+// Publish implements Broker.
+func (b *LocalBroker) Publish(ctx context.Context, channel string, message *Message, instanceID string) error {
+	b.broadcast(BrokerEvent{
+		Kind:     BrokerEventMessage,
+		Channel:  channel,
+		Message:  message,
+		Instance: instanceID,
+	})
+
+	return nil
+}
+
+// CloseChannel implements Broker.
+func (b *LocalBroker) CloseChannel(channel string, instanceID string) error {
+	b.broadcast(BrokerEvent{
+		Kind:     BrokerEventChannelClosed,
+		Channel:  channel,
+		Instance: instanceID,
+	})
+
+	return nil
+}
+
+// broadcast fans event out to every subscriber without blocking: a
+// subscriber whose 256-slot buffer is full (its dispatchBrokerEvents is
+// stalled, e.g. stuck in Channel.deliver under BackpressurePolicy Block for
+// one of its own slow clients) has this event dropped instead of wedging
+// every other instance's Publish/CloseChannel behind it.
+func (b *LocalBroker) broadcast(event BrokerEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe implements Broker.
+func (b *LocalBroker) Subscribe(ctx context.Context) (<-chan BrokerEvent, error) {
+	sub := make(chan BrokerEvent, 256)
+
+	b.mu.Lock()
+	b.subs[sub] = true
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		delete(b.subs, sub)
+		b.mu.Unlock()
+
+		close(sub)
+	}()
+
+	return sub, nil
+}
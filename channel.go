@@ -1,11 +1,12 @@
 package sse
 
 import (
+	"context"
 	"sync"
 	"time"
 )
 
-const sendMessageToClientTimeout = 200 * time.Millisecond
+const defaultSendTimeout = 200 * time.Millisecond
 
 // Channel represents a server sent events channel.
 type Channel struct {
@@ -13,46 +14,234 @@ type Channel struct {
 	lastEventID string
 	name        string
 	clients     map[*Client]bool
+	log         []*Message
+	options     *Options
+
+	// brokerDiscovered and createdAt are set once at construction and never
+	// mutated afterward, so they're safe to read without c.mu. They exist
+	// purely for Server.reapIdleBrokerChannels: brokerDiscovered marks a
+	// channel dispatchBrokerEvents created to hold a replay log for a
+	// channel name with no local subscriber, and createdAt is what its idle
+	// TTL is measured against.
+	brokerDiscovered bool
+	createdAt        time.Time
 }
 
-func newChannel(name string) *Channel {
+func newChannel(name string, options *Options) *Channel {
 	return &Channel{
 		sync.RWMutex{},
 		"",
 		name,
 		make(map[*Client]bool),
+		nil,
+		options,
+		false,
+		time.Now(),
 	}
 }
 
-// SendMessage broadcast a message to all clients in a channel.
+// SendMessage broadcasts a message to all clients in a channel, appending it
+// to the replay log first. The append and the snapshot of clients to deliver
+// to happen under the same lock as addClient, so a client that subscribes
+// concurrently either sees the message via replay or via the broadcast, never
+// both and never neither; the delivery itself runs after that lock is released.
+//
+// How a client whose buffer is full gets handled is controlled by
+// Options.BackpressurePolicy; Options.OnSlowClient, if set, is called once
+// for every client that policy had to react to.
+//
+// Deprecated: use SendMessageContext, which also stops broadcasting as soon
+// as its context is done instead of always visiting every client.
 func (c *Channel) SendMessage(message *Message) {
+	c.SendMessageContext(context.Background(), message)
+}
+
+// SendMessageContext is SendMessage with cancellation: once ctx is done the
+// broadcast loop stops visiting further clients, and BackpressurePolicy Block
+// gives up waiting on the client it's currently stuck on, instead of each
+// client getting its own fresh Options.SendTimeout timer regardless of how
+// long the whole broadcast has already run.
+func (c *Channel) SendMessageContext(ctx context.Context, message *Message) {
 	c.mu.Lock()
 	c.lastEventID = message.id
+	c.appendToLog(message)
+
+	clients := make([]*Client, 0, len(c.clients))
+	for client := range c.clients {
+		clients = append(clients, client)
+	}
 	c.mu.Unlock()
 
-	timer := time.NewTimer(sendMessageToClientTimeout)
-	defer timer.Stop()
+	// Deliver outside of c.mu: a client joining or leaving a different
+	// channel never has to wait on this channel's lock, and under
+	// BackpressurePolicy Block this loop itself can wait out
+	// Options.SendTimeout per client. The log append and the clients
+	// snapshot above still happen atomically, so a client that subscribes
+	// concurrently either picks this message up via replay or via the
+	// snapshot taken here, never both and never neither.
+	var slow []*Client
+	for _, client := range clients {
+		if ctx.Err() != nil {
+			break
+		}
+		if !c.deliver(ctx, client, message) {
+			slow = append(slow, client)
+		}
+	}
+
+	for _, client := range slow {
+		if c.options.OnSlowClient != nil {
+			c.options.OnSlowClient(client, message)
+		}
+		if c.options.BackpressurePolicy == DisconnectSlow {
+			c.removeClient(client)
+		}
+	}
+}
+
+// deliver sends message to client according to Options.BackpressurePolicy,
+// returning false if client was too slow to keep up so the caller can react.
+// It also gives up the moment ctx is done.
+//
+// deliver holds client's own read lock for the whole attempt, not c.mu - a
+// Block policy's wait only ever stalls this one client, never the rest of
+// the channel or the server's dispatch goroutine. The read lock is what
+// close() waits on before it closes client.send, so a close racing this
+// call can't land on it mid-send.
+func (c *Channel) deliver(ctx context.Context, client *Client, message *Message) bool {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	if client.closed {
+		// Already gone; nothing to deliver, and not a sign this client is
+		// slow, so report success rather than having the caller treat it
+		// as one to react to via OnSlowClient/DisconnectSlow.
+		return true
+	}
+
+	switch c.options.BackpressurePolicy {
+	case DropOldest:
+		select {
+		case client.send <- message:
+			return true
+		default:
+		}
+
+		// The buffer was full a moment ago, but client's own consumer may
+		// have drained it concurrently since - only count this as a slow
+		// client if something was actually dropped, not just because the
+		// first attempt above happened to lose the race.
+		dropped := false
+
+		select {
+		case <-client.send:
+			client.recordDropped()
+			dropped = true
+		default:
+		}
+
+		select {
+		case client.send <- message:
+		default:
+			client.recordDropped()
+			dropped = true
+		}
+
+		return !dropped
+
+	case Block:
+		select {
+		case client.send <- message:
+			return true
+		default:
+		}
+
+		timer := time.NewTimer(c.options.sendTimeout())
+		defer timer.Stop()
+
+		select {
+		case client.send <- message:
+			return true
+		case <-timer.C:
+			client.recordDropped()
+			return false
+		case <-ctx.Done():
+			client.recordDropped()
+			return false
+		}
+
+	case DisconnectSlow:
+		select {
+		case client.send <- message:
+			return true
+		default:
+			client.recordDropped()
+			return false
+		}
+
+	default: // DropNewest
+		select {
+		case client.send <- message:
+			return true
+		default:
+			client.recordDropped()
+			return false
+		}
+	}
+}
 
+// appendToLog stores message in the replay log, evicting the oldest entry
+// once Options.ReplayBufferSize is reached. c.mu must be held by the caller.
+func (c *Channel) appendToLog(message *Message) {
+	size := c.options.ReplayBufferSize
+	if size <= 0 {
+		return
+	}
+
+	c.log = append(c.log, message)
+	if len(c.log) > size {
+		c.log = c.log[len(c.log)-size:]
+	}
+}
+
+// replaySince returns every message logged after lastEventID, in order, and
+// whether lastEventID was found in the log. c.mu must be held by the caller.
+func (c *Channel) replaySince(lastEventID string) ([]*Message, bool) {
+	for i, msg := range c.log {
+		if msg.id == lastEventID {
+			return c.log[i+1:], true
+		}
+	}
+
+	return nil, false
+}
+
+// clientsDrained reports whether every client in the channel has an empty
+// send buffer.
+func (c *Channel) clientsDrained() bool {
 	c.mu.RLock()
-	for c, open := range c.clients {
-		if open {
-			// we send message to client, but with timeout since it is possible for
-			// the client channel message to be full, if we don't set timeout we will
-			// block the entire publishing process
-			select {
-			case c.send <- message:
-			case <-timer.C:
-			}
-			timer.Reset(sendMessageToClientTimeout)
+	defer c.mu.RUnlock()
+
+	for client := range c.clients {
+		if len(client.send) > 0 {
+			return false
 		}
 	}
-	c.mu.RUnlock()
+
+	return true
 }
 
 // Close closes the channel and disconnect all clients.
 func (c *Channel) Close() {
-	// Kick all clients of this channel.
+	c.mu.RLock()
+	clients := make([]*Client, 0, len(c.clients))
 	for client := range c.clients {
+		clients = append(clients, client)
+	}
+	c.mu.RUnlock()
+
+	// Kick all clients of this channel.
+	for _, client := range clients {
 		c.removeClient(client)
 	}
 }
@@ -77,10 +266,74 @@ func (c *Channel) addClient(client *Client) {
 	c.mu.Unlock()
 }
 
+// addClientWithReplay replays any buffered messages after the client's
+// Last-Event-ID before adding it to the live broadcast set. The snapshot of
+// what to replay and the subscription both happen under a single lock, so
+// SendMessage can't slip a message in between the replay and the
+// subscription and cause a duplicate or a gap.
+//
+// If the id is empty the client isn't reconnecting, so replay is skipped. If
+// the id isn't found in the log (it rotated out) and Options.RotatedReplayRetry
+// is set, the client instead gets a retry-only message telling it how long to
+// wait before reconnecting with a fresh id.
+//
+// Replay messages go through deliver, same as a live broadcast, but - like
+// SendMessageContext - outside of c.mu: a reconnect can have several messages
+// to replay, and under BackpressurePolicy Block each one can wait out
+// Options.SendTimeout. This runs on dispatch's single goroutine, and dispatch
+// serves every channel's add/remove/close plus shutdown, so holding c.mu
+// across that wait would freeze the whole server, not just this channel.
+func (c *Channel) addClientWithReplay(ctx context.Context, client *Client) {
+	c.mu.Lock()
+
+	var msgs []*Message
+	var retryOnly *Message
+	if client.lastEventID != "" {
+		if found, ok := c.replaySince(client.lastEventID); ok {
+			msgs = found
+		} else if retry := c.options.RotatedReplayRetry; retry > 0 {
+			retryOnly = &Message{retry: retry}
+		}
+	}
+
+	c.clients[client] = true
+	c.mu.Unlock()
+
+	// client was added to the live set above, inside the same lock that
+	// produced msgs/retryOnly, so a SendMessage racing this call either
+	// lands in the snapshot here or gets broadcast to client directly,
+	// never both and never neither.
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			break
+		}
+		c.deliver(ctx, client, msg)
+	}
+	if retryOnly != nil && ctx.Err() == nil {
+		c.deliver(ctx, client, retryOnly)
+	}
+}
+
+// removeClient drops client from the live broadcast set and closes its send
+// channel. The map removal always happens here, synchronously, so
+// ClientCount reflects it immediately. The close itself is delegated to
+// Client.close, which never blocks this call: if a deliver for this exact
+// client is already stuck under BackpressurePolicy Block, close finishes on
+// its own goroutine instead. That matters because removeClient runs on the
+// server's single dispatch goroutine, which serially drains every channel's
+// connects and disconnects - blocking here over one stuck client would stall
+// all of them.
+//
+// existed guards against a client being removed twice, e.g. once via
+// BackpressurePolicy DisconnectSlow and once more through the normal
+// ServeHTTP disconnect path once that DisconnectSlow close unblocks it.
 func (c *Channel) removeClient(client *Client) {
 	c.mu.Lock()
-	c.clients[client] = false
+	_, existed := c.clients[client]
 	delete(c.clients, client)
 	c.mu.Unlock()
-	close(client.send)
+
+	if existed {
+		client.close()
+	}
 }
@@ -1,19 +1,41 @@
 package sse
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const defaultSendBufferSize = 1024
+
 // Client represents a web browser connection.
 type Client struct {
 	lastEventID,
 	channel string
-	send chan *Message
+	send    chan *Message
+	dropped uint64
+
+	// mu guards close against a concurrent deliver: deliver holds a read
+	// lock for as long as it's attempting to send, including a Block
+	// policy's wait, so close can't race it into a send-on-closed-channel
+	// panic by closing send out from under an in-flight attempt.
+	mu     sync.RWMutex
+	closed bool
 }
 
-func newClient(lastEventID, channel string) *Client {
+func newClient(lastEventID, channel string, sendBufferSize int) *Client {
+	if sendBufferSize <= 0 {
+		sendBufferSize = defaultSendBufferSize
+	}
+
 	return &Client{
 		lastEventID,
 		channel,
 		// use buffered channel so client could still receive message event though it is busy,
 		// this is to minimize message loss in client
-		make(chan *Message, 1024),
+		make(chan *Message, sendBufferSize),
+		0,
+		sync.RWMutex{},
+		false,
 	}
 }
 
@@ -32,3 +54,48 @@ func (c *Client) Channel() string {
 func (c *Client) LastEventID() string {
 	return c.lastEventID
 }
+
+// ClientStats holds a snapshot of a Client's backpressure counters.
+type ClientStats struct {
+	// Dropped is the number of messages this client missed because its send
+	// buffer was full, under whichever Options.BackpressurePolicy applies.
+	Dropped uint64
+}
+
+// Stats returns a snapshot of this client's backpressure counters.
+func (c *Client) Stats() ClientStats {
+	return ClientStats{Dropped: atomic.LoadUint64(&c.dropped)}
+}
+
+func (c *Client) recordDropped() {
+	atomic.AddUint64(&c.dropped, 1)
+}
+
+// close closes send exactly once. If a deliver call is currently holding the
+// read lock (e.g. waiting out a Block policy's SendTimeout), the close is
+// finished on a separate goroutine instead of here, so a caller on the
+// server's single dispatch goroutine never waits out that same timeout just
+// to remove one client.
+func (c *Client) close() {
+	if c.mu.TryLock() {
+		c.closeLocked()
+		return
+	}
+
+	go func() {
+		c.mu.Lock()
+		c.closeLocked()
+	}()
+}
+
+// closeLocked closes send. c.mu must be held for writing by the caller, which
+// is released here.
+func (c *Client) closeLocked() {
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.send)
+}
@@ -0,0 +1,87 @@
+package sse
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message represents a single server sent event.
+type Message struct {
+	id    string
+	event string
+	data  string
+	retry time.Duration
+}
+
+// NewMessage creates a Message with the given id, event and data.
+func NewMessage(id, event, data string) *Message {
+	return &Message{
+		id:    id,
+		event: event,
+		data:  data,
+	}
+}
+
+// SimpleMessage creates a Message with only its data field set.
+func SimpleMessage(data string) *Message {
+	return &Message{data: data}
+}
+
+// ID returns the message's event id.
+func (m *Message) ID() string {
+	return m.id
+}
+
+// Event returns the message's event name.
+func (m *Message) Event() string {
+	return m.event
+}
+
+// Data returns the message's data payload.
+func (m *Message) Data() string {
+	return m.data
+}
+
+// Bytes serializes the message into the wire format described by the
+// Server-Sent Events spec.
+func (m *Message) Bytes() []byte {
+	return m.bytesWithRetry(m.retry)
+}
+
+// bytesWithRetry is Bytes with the retry field overridden. This lets a
+// caller that broadcasts the same *Message to many connections (and, once
+// it's in a channel's replay log, to every future reconnecting client too)
+// give each connection its own retry line - e.g. Options.RetryInterval -
+// without mutating the shared Message out from under a concurrent send of
+// it elsewhere.
+func (m *Message) bytesWithRetry(retry time.Duration) []byte {
+	var b bytes.Buffer
+
+	if len(m.id) > 0 {
+		fmt.Fprintf(&b, "id: %s\n", m.id)
+	}
+
+	if len(m.event) > 0 {
+		fmt.Fprintf(&b, "event: %s\n", m.event)
+	}
+
+	if retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", retry/time.Millisecond)
+	}
+
+	// An empty data field isn't "one empty data line" - it's no payload at
+	// all, e.g. a retry-only message telling a client how long to wait
+	// before reconnecting. Emitting "data: \n" here would still dispatch a
+	// real (empty) message event on the client.
+	if len(m.data) > 0 {
+		for _, line := range strings.Split(m.data, "\n") {
+			fmt.Fprintf(&b, "data: %s\n", line)
+		}
+	}
+
+	b.WriteString("\n")
+
+	return b.Bytes()
+}
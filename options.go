@@ -0,0 +1,131 @@
+package sse
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+const defaultBrokerChannelIdleTTL = time.Minute
+
+// Options are used to configure a Server.
+type Options struct {
+	// Logger is used to log what's happening inside the server, default to
+	// standard log.Logger.
+	Logger *log.Logger
+
+	// DontStartServer prevents the server from starting immediately after
+	// being created with NewServer, callers must call Start manually.
+	DontStartServer bool
+
+	// ChannelNameFunc is used to resolve the channel name for an incoming
+	// request, defaults to using the request path.
+	ChannelNameFunc func(r *http.Request) string
+
+	// Headers are additional headers sent alongside every SSE response.
+	Headers map[string]string
+
+	// RetryInterval sets the "retry" field sent to clients, telling them how
+	// long to wait before attempting to reconnect.
+	RetryInterval time.Duration
+
+	// ReplayBufferSize is the number of past messages kept per channel so
+	// that a reconnecting client can replay everything it missed via
+	// Last-Event-ID. A value <= 0 disables replay (the default).
+	ReplayBufferSize int
+
+	// RotatedReplayRetry controls what happens when a client reconnects with
+	// a Last-Event-ID that has since rotated out of the replay log. If > 0,
+	// the client is sent a retry-only message with this interval instead of
+	// a replay. If 0, the client simply starts fresh with no replay.
+	RotatedReplayRetry time.Duration
+
+	// BrokerBackend, when set, fans messages and channel lifecycle events
+	// out through a Broker instead of only within this process, allowing
+	// multiple Server instances to sit behind a load balancer and still
+	// reach every connected client. Defaults to nil, i.e. this process only.
+	BrokerBackend Broker
+
+	// SendBufferSize is the number of messages buffered per client before
+	// BackpressurePolicy kicks in. Defaults to 1024.
+	SendBufferSize int
+
+	// SendTimeout bounds how long BackpressurePolicy Block waits for room in
+	// a full client buffer before giving up on that message. Defaults to
+	// 200ms.
+	SendTimeout time.Duration
+
+	// BackpressurePolicy controls what happens when a client's send buffer
+	// is full. Defaults to DropNewest.
+	BackpressurePolicy BackpressurePolicy
+
+	// OnSlowClient, if set, is called whenever BackpressurePolicy had to
+	// react to a client whose buffer was full, alongside the usual
+	// Client.Stats() counters.
+	OnSlowClient func(*Client, *Message)
+
+	// HeartbeatInterval, when > 0, makes the server write an SSE comment
+	// line on every connection at this interval. This keeps the underlying
+	// TCP connection busy so reverse proxies and load balancers that close
+	// idle streams don't mistake a quiet channel for a dead one. Heartbeats
+	// never advance a client's Last-Event-ID. Defaults to 0, i.e. disabled.
+	HeartbeatInterval time.Duration
+
+	// OnClientIdleTimeout, when > 0, disconnects a client if no message has
+	// been delivered to it within this window, heartbeats aside. The client
+	// is expected to reconnect and replay whatever it missed via
+	// Last-Event-ID. Defaults to 0, i.e. disabled.
+	OnClientIdleTimeout time.Duration
+
+	// BrokerChannelIdleTTL bounds how long this instance keeps a channel it
+	// only knows about through BrokerBackend (i.e. one with no local
+	// subscriber of its own) around after it stops having any local clients.
+	// Without this, an instance that never gets a direct subscriber for some
+	// channel name accumulates one phantom *Channel per distinct name
+	// published through the broker, forever. Reclaiming one only drops this
+	// instance's local copy - it isn't announced to the broker, so it's
+	// simply recreated the next time a message for that channel arrives
+	// here. Only consulted when BrokerBackend is set. Defaults to 1 minute.
+	BrokerChannelIdleTTL time.Duration
+}
+
+func (o *Options) hasHeaders() bool {
+	return len(o.Headers) > 0
+}
+
+func (o *Options) sendTimeout() time.Duration {
+	if o.SendTimeout > 0 {
+		return o.SendTimeout
+	}
+	return defaultSendTimeout
+}
+
+func (o *Options) brokerChannelIdleTTL() time.Duration {
+	if o.BrokerChannelIdleTTL > 0 {
+		return o.BrokerChannelIdleTTL
+	}
+	return defaultBrokerChannelIdleTTL
+}
+
+// BackpressurePolicy controls what a Channel does when a client's send
+// buffer is full.
+type BackpressurePolicy int
+
+const (
+	// DropNewest drops the message currently being sent if the client's
+	// buffer is full. This is the default.
+	DropNewest BackpressurePolicy = iota
+
+	// DropOldest evicts the oldest buffered message to make room for the
+	// new one, so the client stays current at the cost of the past.
+	DropOldest
+
+	// Block waits up to Options.SendTimeout for room in the client's buffer
+	// before giving up and dropping the message.
+	Block
+
+	// DisconnectSlow disconnects the client the moment its buffer is found
+	// full, so it can reconnect and replay what it missed via
+	// Last-Event-ID.
+	DisconnectSlow
+)
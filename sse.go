@@ -1,7 +1,9 @@
 package sse
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -24,7 +26,12 @@ type Server struct {
 	removeClient chan *Client
 	shutdown     chan bool
 	closeChannel chan string
+	done         chan struct{}
 	isStarted    bool
+	draining     bool
+	httpWG       sync.WaitGroup
+	instanceID   string
+	brokerCancel context.CancelFunc
 }
 
 // NewServer creates a new SSE server.
@@ -47,7 +54,12 @@ func NewServer(options *Options) *Server {
 		make(chan *Client, 256), // we use buffered channel, to minimize blocking when sending signal
 		make(chan bool),
 		make(chan string),
+		make(chan struct{}),
 		false,
+		false,
+		sync.WaitGroup{},
+		fmt.Sprintf("%p-%d", options, time.Now().UnixNano()),
+		nil,
 	}
 
 	// by default the server will start immediately, however sometimes we don't
@@ -65,6 +77,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.isDraining() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming unsupported.", http.StatusInternalServerError)
@@ -93,21 +110,30 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		lastEventID := r.Header.Get("Last-Event-ID")
-		c := newClient(lastEventID, channelName)
+		c := newClient(lastEventID, channelName, s.options.SendBufferSize)
 		closeNotify := r.Context().Done()
 
 		select {
 		case s.addClient <- c:
 		case <-closeNotify:
 			return
+		case <-s.done:
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
 		}
 
+		// track this goroutine so ShutdownContext can wait for it to finish
+		// streaming before the server tears itself down.
+		s.httpWG.Add(1)
+		defer s.httpWG.Done()
+
 		// defer function to remove client from channel, here we give timeout
 		// 1 second for inserting the request to s.removeClient.
 		defer func() {
 			select {
 			case s.removeClient <- c:
 			case <-time.After(1 * time.Second):
+			case <-s.done:
 			}
 		}()
 
@@ -115,17 +141,64 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		flusher.Flush()
 
+		var heartbeat *time.Ticker
+		if s.options.HeartbeatInterval > 0 {
+			heartbeat = time.NewTicker(s.options.HeartbeatInterval)
+			defer heartbeat.Stop()
+		}
+
+		var idleTimer *time.Timer
+		if s.options.OnClientIdleTimeout > 0 {
+			idleTimer = time.NewTimer(s.options.OnClientIdleTimeout)
+			defer idleTimer.Stop()
+		}
+
 		// stream event source to client
 		for {
+			var heartbeatC <-chan time.Time
+			if heartbeat != nil {
+				heartbeatC = heartbeat.C
+			}
+
+			var idleC <-chan time.Time
+			if idleTimer != nil {
+				idleC = idleTimer.C
+			}
+
 			select {
 			case <-closeNotify:
 				return
+			case <-idleC:
+				return
+			case <-heartbeatC:
+				// a heartbeat is a bare SSE comment, it must not touch
+				// lastEventID or go through Message.Bytes.
+				w.Write([]byte(": ping\n\n"))
+				flusher.Flush()
 			case msg, ok := <-c.send:
 				if !ok {
 					return
 				}
-				msg.retry = s.options.RetryInterval
-				w.Write(msg.Bytes())
+				if idleTimer != nil {
+					if !idleTimer.Stop() {
+						select {
+						case <-idleTimer.C:
+						default:
+						}
+					}
+					idleTimer.Reset(s.options.OnClientIdleTimeout)
+				}
+				// msg may be the same *Message concurrently broadcast to other
+				// clients of this channel, or sitting in its replay log for
+				// future reconnects - bytesWithRetry renders this connection's
+				// retry line without mutating it out from under them. A message
+				// that carries its own retry (the retry-only message sent for a
+				// rotated-out Last-Event-ID) keeps it.
+				retry := s.options.RetryInterval
+				if msg.retry > 0 {
+					retry = msg.retry
+				}
+				w.Write(msg.bytesWithRetry(retry))
 				flusher.Flush()
 			}
 		}
@@ -135,8 +208,25 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 // SendMessage broadcast a message to all clients in a channel.
-// If channelName is an empty string, it will broadcast the message to all channels.
+// If channelName is an empty string, it will broadcast the message to all
+// channels this instance currently knows about.
+//
+// If Options.BrokerBackend is set, messages are published through it instead
+// of being fanned out locally, so that every instance sharing the broker
+// delivers them to its own connected clients, even if channelName has no
+// clients on this particular instance.
+//
+// Deprecated: use SendMessageContext, which gives up once its context is
+// done instead of potentially blocking forever on a full client buffer or a
+// broadcast to many channels.
 func (s *Server) SendMessage(channelName string, message *Message) error {
+	return s.SendMessageContext(context.Background(), channelName, message)
+}
+
+// SendMessageContext is SendMessage with cancellation: it stops as soon as
+// ctx is done instead of waiting out BackpressurePolicy's timers across
+// every target channel and client.
+func (s *Server) SendMessageContext(ctx context.Context, channelName string, message *Message) error {
 	if !s.hasStarted() {
 		return ErrServerNotStarted
 	}
@@ -144,14 +234,33 @@ func (s *Server) SendMessage(channelName string, message *Message) error {
 		s.options.Logger.Print("broadcasting message to all channels.")
 
 		s.mu.RLock()
+		names := make([]string, 0, len(s.channels))
+		for name := range s.channels {
+			names = append(names, name)
+		}
+		s.mu.RUnlock()
 
-		for _, ch := range s.channels {
-			ch.SendMessage(message)
+		for _, name := range names {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := s.publish(ctx, name, message); err != nil {
+				return err
+			}
 		}
 
-		s.mu.RUnlock()
-	} else if ch, ok := s.getChannel(channelName); ok {
-		ch.SendMessage(message)
+		return nil
+	}
+
+	if s.options.BrokerBackend != nil {
+		if err := s.publish(ctx, channelName, message); err != nil {
+			return err
+		}
+		s.options.Logger.Printf("message published to channel '%s'.", channelName)
+	} else if _, ok := s.getChannel(channelName); ok {
+		if err := s.publish(ctx, channelName, message); err != nil {
+			return err
+		}
 		s.options.Logger.Printf("message sent to channel '%s'.", channelName)
 	} else {
 		s.options.Logger.Printf("message not sent because channel '%s' has no clients.", channelName)
@@ -160,12 +269,50 @@ func (s *Server) SendMessage(channelName string, message *Message) error {
 	return nil
 }
 
-// Start is used for starting the server
+// publish delivers message to channelName, through the broker if one is
+// configured, or directly to the local channel otherwise.
+func (s *Server) publish(ctx context.Context, channelName string, message *Message) error {
+	if s.options.BrokerBackend != nil {
+		return s.options.BrokerBackend.Publish(ctx, channelName, message, s.instanceID)
+	}
+
+	if ch, ok := s.getChannel(channelName); ok {
+		ch.SendMessageContext(ctx, message)
+	}
+
+	return nil
+}
+
+// Start is used for starting the server.
+//
+// Deprecated: use StartContext, which threads a cancellable context into the
+// dispatch goroutine instead of tying it to context.Background().
 func (s *Server) Start() error {
+	return s.StartContext(context.Background())
+}
+
+// StartContext is Start with a caller-supplied context: dispatch stops as
+// soon as ctx is done, in addition to the existing Shutdown/ShutdownContext
+// path.
+func (s *Server) StartContext(ctx context.Context) error {
 	if s.hasStarted() {
 		return ErrServerStarted
 	}
-	go s.dispatch()
+
+	if s.options.BrokerBackend != nil {
+		brokerCtx, cancel := context.WithCancel(ctx)
+
+		events, err := s.options.BrokerBackend.Subscribe(brokerCtx)
+		if err != nil {
+			cancel()
+			return fmt.Errorf("subscribing to broker: %w", err)
+		}
+
+		s.brokerCancel = cancel
+		go s.dispatchBrokerEvents(events)
+	}
+
+	go s.dispatch(ctx)
 
 	s.mu.Lock()
 	s.isStarted = true
@@ -174,6 +321,33 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// dispatchBrokerEvents applies every BrokerEvent received through the
+// configured Broker to local state: messages are fanned out to the matching
+// local channel (creating it first if this instance has no clients on it
+// yet, e.g. it only learned about the channel from another instance), and
+// channel-closed events close the local channel. Events this instance itself
+// published are skipped for BrokerEventChannelClosed, since the local close
+// already happened synchronously through CloseChannel.
+func (s *Server) dispatchBrokerEvents(events <-chan BrokerEvent) {
+	for event := range events {
+		switch event.Kind {
+		case BrokerEventChannelClosed:
+			if event.Instance == s.instanceID {
+				continue
+			}
+			if ch, exists := s.getChannel(event.Channel); exists {
+				s.removeChannel(ch)
+			}
+		default:
+			ch, exists := s.getChannel(event.Channel)
+			if !exists {
+				ch = s.addBrokerChannel(event.Channel)
+			}
+			ch.SendMessage(event.Message)
+		}
+	}
+}
+
 // Restart closes all channels and clients and allow new connections.
 func (s *Server) Restart() error {
 	if !s.hasStarted() {
@@ -185,16 +359,129 @@ func (s *Server) Restart() error {
 	return nil
 }
 
-// Shutdown performs a graceful server shutdown.
+// Shutdown performs a graceful server shutdown with no deadline. It is a thin
+// wrapper around ShutdownContext using context.Background().
 func (s *Server) Shutdown() error {
+	return s.ShutdownContext(context.Background())
+}
+
+// ShutdownContext performs a graceful server shutdown: it stops accepting new
+// clients, waits for every client's send buffer to drain, stops the server
+// (which closes every client's connection), then waits for every ServeHTTP
+// goroutine to return. If ctx is done before that happens, it forcibly closes
+// the remaining connections and returns ctx.Err().
+func (s *Server) ShutdownContext(ctx context.Context) error {
 	if !s.hasStarted() {
 		return ErrServerNotStarted
 	}
-	s.shutdown <- true
+
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+	s.options.Logger.Print("server draining, no longer accepting new clients.")
+
+	drained := make(chan struct{})
+	go func() {
+		s.waitForDrain(ctx)
+		close(drained)
+	}()
+
+	deadlineHit := false
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		deadlineHit = true
+	}
+
+	// Stop dispatch and forcibly close every client's send channel. This is
+	// what unblocks the ServeHTTP goroutines below, whether or not every
+	// buffer fully drained above. If dispatch is wedged and never picks this
+	// signal up before ctx is done, fall back to closing every channel's
+	// clients directly instead of waiting on dispatch forever.
+	//
+	// hasStarted is re-checked here rather than trusting the one at the top
+	// of this function: dispatch may have stopped itself in the meantime
+	// (StartContext's ctx being cancelled), in which case s.shutdown is
+	// already closed and sending on it would panic.
+	if s.hasStarted() {
+		select {
+		case s.shutdown <- true:
+		case <-ctx.Done():
+			deadlineHit = true
+			s.forceCloseClients()
+		}
+	}
+
+	httpDone := make(chan struct{})
+	go func() {
+		s.httpWG.Wait()
+		close(httpDone)
+	}()
+
+	select {
+	case <-httpDone:
+	case <-ctx.Done():
+		deadlineHit = true
+	}
+
+	if deadlineHit {
+		return ctx.Err()
+	}
 
 	return nil
 }
 
+// waitForDrain blocks until every connected client's send buffer is empty or
+// ctx is done, whichever comes first.
+func (s *Server) waitForDrain(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !s.allClientsDrained() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// forceCloseClients closes every channel's clients directly, bypassing
+// dispatch, for use when dispatch itself isn't responding to s.shutdown in
+// time.
+func (s *Server) forceCloseClients() {
+	s.mu.RLock()
+	channels := make([]*Channel, 0, len(s.channels))
+	for _, ch := range s.channels {
+		channels = append(channels, ch)
+	}
+	s.mu.RUnlock()
+
+	for _, ch := range channels {
+		ch.Close()
+	}
+}
+
+func (s *Server) allClientsDrained() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, ch := range s.channels {
+		if !ch.clientsDrained() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Server) isDraining() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.draining
+}
+
 func (s *Server) hasStarted() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -243,13 +530,35 @@ func (s *Server) Channels() []string {
 	return channels
 }
 
-// CloseChannel closes a channel.
+// CloseChannel closes a channel, locally and, if Options.BrokerBackend is
+// set, on every other instance sharing the broker too.
 func (s *Server) CloseChannel(name string) {
-	s.closeChannel <- name
+	if s.options.BrokerBackend != nil {
+		if err := s.options.BrokerBackend.CloseChannel(name, s.instanceID); err != nil {
+			s.options.Logger.Printf("failed to announce close of channel '%s': %v", name, err)
+		}
+	}
+
+	select {
+	case s.closeChannel <- name:
+	case <-s.done:
+	}
 }
 
 func (s *Server) addChannel(name string) *Channel {
-	ch := newChannel(name)
+	return s.newTrackedChannel(name, false)
+}
+
+// addBrokerChannel is addChannel for a channel dispatchBrokerEvents created
+// with no local subscriber of its own, so Server.reapIdleBrokerChannels knows
+// it's a candidate for reclaiming if one never shows up.
+func (s *Server) addBrokerChannel(name string) *Channel {
+	return s.newTrackedChannel(name, true)
+}
+
+func (s *Server) newTrackedChannel(name string, brokerDiscovered bool) *Channel {
+	ch := newChannel(name, s.options)
+	ch.brokerDiscovered = brokerDiscovered
 
 	s.mu.Lock()
 	s.channels[ch.name] = ch
@@ -283,9 +592,47 @@ func (s *Server) close() {
 	}
 }
 
-func (s *Server) dispatch() {
+// reapIdleBrokerChannels drops this instance's local copy of every
+// broker-discovered channel that has had zero local clients since it was
+// created, for longer than Options.BrokerChannelIdleTTL. It only runs when
+// Options.BrokerBackend is set, since that's the only way a channel is ever
+// marked brokerDiscovered.
+//
+// This is purely local bookkeeping, not announced through the broker: a
+// channel reclaimed here is simply recreated the next time a message for it
+// arrives. A channel with local clients, or one that ever had any, is never
+// a candidate - that case is already handled immediately, the moment its
+// last local client disconnects, by the s.removeClient case in dispatch.
+func (s *Server) reapIdleBrokerChannels() {
+	ttl := s.options.brokerChannelIdleTTL()
+
+	s.mu.RLock()
+	var idle []*Channel
+	for _, ch := range s.channels {
+		if ch.brokerDiscovered && ch.ClientCount() == 0 && time.Since(ch.createdAt) > ttl {
+			idle = append(idle, ch)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, ch := range idle {
+		s.removeChannel(ch)
+		s.options.Logger.Printf("reclaimed idle broker-discovered channel '%s'.", ch.name)
+	}
+}
+
+func (s *Server) dispatch(ctx context.Context) {
 	s.options.Logger.Print("server started.")
 
+	// Only a broker-backed server ever creates brokerDiscovered channels, so
+	// only it needs to sweep for idle ones.
+	var reapC <-chan time.Time
+	if s.options.BrokerBackend != nil {
+		reapTicker := time.NewTicker(s.options.brokerChannelIdleTTL())
+		defer reapTicker.Stop()
+		reapC = reapTicker.C
+	}
+
 	for {
 		select {
 
@@ -297,7 +644,7 @@ func (s *Server) dispatch() {
 				ch = s.addChannel(c.channel)
 			}
 
-			ch.addClient(c)
+			ch.addClientWithReplay(ctx, c)
 			s.options.Logger.Printf("new client connected to channel '%s'.", ch.name)
 
 		// Client disconnected.
@@ -320,16 +667,59 @@ func (s *Server) dispatch() {
 				s.options.Logger.Printf("requested to close nonexistent channel '%s'.", channel)
 			}
 
+		// Reclaim broker-discovered channels nobody ever subscribed to
+		// locally.
+		case <-reapC:
+			s.reapIdleBrokerChannels()
+
 		// Event Source shutdown.
 		case <-s.shutdown:
-			s.close()
-			close(s.addClient)
-			close(s.removeClient)
-			close(s.closeChannel)
-			close(s.shutdown)
+			s.stopDispatch()
+			return
 
-			s.options.Logger.Print("server stopped.")
+		// The context passed to StartContext was cancelled.
+		case <-ctx.Done():
+			// mark the server draining before tearing it down, same as
+			// ShutdownContext does, so a ServeHTTP call racing this
+			// cancellation sees "shutting down" instead of slipping past
+			// isDraining's check right as dispatch stops.
+			s.mu.Lock()
+			s.draining = true
+			s.mu.Unlock()
+			s.stopDispatch()
 			return
 		}
 	}
 }
+
+// stopDispatch tears down everything dispatch owns: it marks the server
+// stopped, closes every channel still open, and closes s.done so any
+// goroutine still waiting to send on addClient/removeClient/closeChannel
+// gives up instead of blocking forever.
+//
+// addClient, removeClient and closeChannel are never closed themselves -
+// every ServeHTTP call and every CloseChannel call is its own sender racing
+// every other one of them, and only a channel's sender(s) may close it. s.done
+// is the one channel dispatch alone closes, exactly once, so it's safe to use
+// as that shared "stop sending" signal.
+//
+// isStarted is cleared before s.shutdown is closed, not after, so that a
+// concurrent ShutdownContext/Shutdown call - e.g. one racing this same
+// dispatch stopping because the context passed to StartContext was
+// cancelled - never observes isStarted still true and then sends on an
+// s.shutdown that's already closed.
+func (s *Server) stopDispatch() {
+	s.mu.Lock()
+	s.isStarted = false
+	s.mu.Unlock()
+
+	s.close()
+	close(s.done)
+	close(s.shutdown)
+
+	if s.brokerCancel != nil {
+		s.brokerCancel()
+	}
+
+	s.options.Logger.Print("server stopped.")
+}
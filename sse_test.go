@@ -4,10 +4,14 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -65,7 +69,7 @@ func TestServer(t *testing.T) {
 			wg.Add(1)
 
 			// Create new client
-			c := newClient("", name)
+			c := newClient("", name, 0)
 			// Add client to current channel
 			ch.addClient(c)
 
@@ -189,3 +193,482 @@ func TestServerDontStartServer(t *testing.T) {
 	// check for number of message received
 	assert.Equal(t, numChannels*numSubscribersEachChannel, countMessageReceived)
 }
+
+func TestChannelReplayFound(t *testing.T) {
+	ch := newChannel("test", &Options{ReplayBufferSize: 10})
+
+	ch.SendMessage(NewMessage("1", "", "one"))
+	ch.SendMessage(NewMessage("2", "", "two"))
+	ch.SendMessage(NewMessage("3", "", "three"))
+
+	c := newClient("2", "test", 0)
+	ch.addClientWithReplay(context.Background(), c)
+
+	close(c.send)
+
+	var got []string
+	for msg := range c.send {
+		got = append(got, msg.id)
+	}
+
+	assert.Equal(t, []string{"3"}, got)
+	assert.True(t, ch.ClientCount() == 1)
+}
+
+func TestChannelReplayRotatedOut(t *testing.T) {
+	ch := newChannel("test", &Options{ReplayBufferSize: 2, RotatedReplayRetry: 50 * time.Millisecond})
+
+	ch.SendMessage(NewMessage("1", "", "one"))
+	ch.SendMessage(NewMessage("2", "", "two"))
+	ch.SendMessage(NewMessage("3", "", "three"))
+
+	// id "1" has rotated out of the 2-entry log.
+	c := newClient("1", "test", 0)
+	ch.addClientWithReplay(context.Background(), c)
+
+	close(c.send)
+
+	msgs := make([]*Message, 0)
+	for msg := range c.send {
+		msgs = append(msgs, msg)
+	}
+
+	require.Len(t, msgs, 1)
+	assert.Empty(t, msgs[0].id)
+	assert.Equal(t, 50*time.Millisecond, msgs[0].retry)
+}
+
+func TestChannelReplayEmptyLastEventID(t *testing.T) {
+	ch := newChannel("test", &Options{ReplayBufferSize: 10})
+
+	ch.SendMessage(NewMessage("1", "", "one"))
+
+	c := newClient("", "test", 0)
+	ch.addClientWithReplay(context.Background(), c)
+
+	close(c.send)
+
+	var got []*Message
+	for msg := range c.send {
+		got = append(got, msg)
+	}
+
+	assert.Empty(t, got)
+	assert.True(t, ch.ClientCount() == 1)
+}
+
+func TestChannelReplayRaceWithBroadcast(t *testing.T) {
+	ch := newChannel("test", &Options{ReplayBufferSize: 100})
+
+	ch.SendMessage(NewMessage("1", "", "one"))
+
+	var wg sync.WaitGroup
+	clientCount := 20
+	clients := make([]*Client, clientCount)
+
+	for i := 0; i < clientCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := newClient("1", "test", 0)
+			ch.addClientWithReplay(context.Background(), c)
+			clients[i] = c
+		}(i)
+	}
+
+	// Concurrently keep publishing while clients subscribe, each subscriber
+	// must see every message published after its Last-Event-ID exactly once,
+	// regardless of when it joins relative to these broadcasts.
+	for i := 2; i <= 5; i++ {
+		ch.SendMessage(NewMessage(fmt.Sprintf("%d", i), "", "msg"))
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, clientCount, ch.ClientCount())
+
+	// Every client must have received a gapless, non-duplicated suffix of
+	// ids ending at the last published message, regardless of whether its
+	// replay raced a broadcast still in flight.
+	for i, c := range clients {
+		close(c.send)
+
+		var got []int
+		for msg := range c.send {
+			id, err := strconv.Atoi(msg.id)
+			require.NoError(t, err)
+			got = append(got, id)
+		}
+
+		require.NotEmpty(t, got, "client %d received no messages", i)
+		for j := 1; j < len(got); j++ {
+			assert.Equal(t, got[j-1]+1, got[j], "client %d: gap or duplicate in %v", i, got)
+		}
+		assert.Equal(t, 5, got[len(got)-1], "client %d: last message wasn't the final published id", i)
+	}
+}
+
+func TestServerShutdownContextDrainSuccess(t *testing.T) {
+	srv := NewServer(&Options{
+		Logger: log.New(ioutil.Discard, "", 0),
+	})
+
+	ch := srv.addChannel("drain-test")
+
+	const clientCount = 5
+
+	var consumerWG sync.WaitGroup
+	for i := 0; i < clientCount; i++ {
+		c := newClient("", "drain-test", 0)
+		ch.addClient(c)
+
+		srv.httpWG.Add(1)
+		consumerWG.Add(1)
+		go func() {
+			defer srv.httpWG.Done()
+			defer consumerWG.Done()
+			for range c.send {
+			}
+		}()
+	}
+
+	require.NoError(t, srv.SendMessage("drain-test", SimpleMessage("hello")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	assert.NoError(t, srv.ShutdownContext(ctx))
+
+	// every consumer goroutine must have observed the closed send channel
+	// and returned, leaving nothing running in the background.
+	consumerWG.Wait()
+}
+
+func TestServerShutdownContextDeadlineExceeded(t *testing.T) {
+	srv := NewServer(&Options{
+		Logger: log.New(ioutil.Discard, "", 0),
+	})
+
+	ch := srv.addChannel("stuck-test")
+	c := newClient("", "stuck-test", 0)
+	ch.addClient(c)
+
+	// simulate a ServeHTTP goroutine that never returns (e.g. a stuck
+	// connection), so the handler-drain step in ShutdownContext can't
+	// complete before the deadline.
+	srv.httpWG.Add(1)
+	defer srv.httpWG.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, srv.ShutdownContext(ctx))
+}
+
+func TestServerBrokerFanOutAcrossInstances(t *testing.T) {
+	broker := NewLocalBroker()
+
+	srvA := NewServer(&Options{Logger: log.New(ioutil.Discard, "", 0), BrokerBackend: broker})
+	defer srvA.Shutdown()
+
+	srvB := NewServer(&Options{Logger: log.New(ioutil.Discard, "", 0), BrokerBackend: broker})
+	defer srvB.Shutdown()
+
+	srvtestB := httptest.NewServer(srvB)
+	defer srvtestB.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := ctxhttp.Get(ctx, http.DefaultClient, srvtestB.URL+"/news")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			if strings.HasPrefix(line, "data: ") {
+				received <- strings.TrimPrefix(line, "data: ")
+				return
+			}
+		}
+	}()
+
+	// wait for instance B's HTTP handler to actually register the channel
+	// before publishing from instance A.
+	require.Eventually(t, func() bool { return srvB.HasChannel("/news") }, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, srvA.SendMessage("/news", SimpleMessage("hello from A")))
+
+	select {
+	case data := <-received:
+		assert.Equal(t, "hello from A", data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message published on instance A never reached instance B")
+	}
+}
+
+func TestServerBrokerCloseChannelPropagatesAcrossInstances(t *testing.T) {
+	broker := NewLocalBroker()
+
+	srvA := NewServer(&Options{Logger: log.New(ioutil.Discard, "", 0), BrokerBackend: broker})
+	defer srvA.Shutdown()
+
+	srvB := NewServer(&Options{Logger: log.New(ioutil.Discard, "", 0), BrokerBackend: broker})
+	defer srvB.Shutdown()
+
+	require.NoError(t, srvB.SendMessage("to-close", SimpleMessage("seed")))
+	require.Eventually(t, func() bool { return srvB.HasChannel("to-close") }, 2*time.Second, 10*time.Millisecond)
+
+	srvA.CloseChannel("to-close")
+
+	assert.Eventually(t, func() bool { return !srvB.HasChannel("to-close") }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestChannelBackpressureDropNewest(t *testing.T) {
+	var slow []*Message
+	ch := newChannel("bp", &Options{
+		BackpressurePolicy: DropNewest,
+		OnSlowClient: func(c *Client, m *Message) {
+			slow = append(slow, m)
+		},
+	})
+
+	c := newClient("", "bp", 1)
+	ch.addClient(c)
+
+	ch.SendMessage(SimpleMessage("one"))
+	ch.SendMessage(SimpleMessage("two")) // buffer full, "two" is dropped
+
+	require.Len(t, slow, 1)
+	assert.Equal(t, "two", slow[0].data)
+	assert.Equal(t, uint64(1), c.Stats().Dropped)
+
+	msg := <-c.send
+	assert.Equal(t, "one", msg.data)
+}
+
+func TestChannelBackpressureDropOldest(t *testing.T) {
+	ch := newChannel("bp", &Options{BackpressurePolicy: DropOldest})
+
+	c := newClient("", "bp", 2)
+	ch.addClient(c)
+
+	ch.SendMessage(SimpleMessage("one"))
+	ch.SendMessage(SimpleMessage("two"))
+	ch.SendMessage(SimpleMessage("three")) // buffer full, evicts "one"
+
+	close(c.send)
+
+	var got []string
+	for msg := range c.send {
+		got = append(got, msg.data)
+	}
+
+	assert.Equal(t, []string{"two", "three"}, got)
+	assert.Equal(t, uint64(1), c.Stats().Dropped)
+}
+
+func TestChannelBackpressureBlock(t *testing.T) {
+	ch := newChannel("bp", &Options{BackpressurePolicy: Block, SendTimeout: 20 * time.Millisecond})
+
+	c := newClient("", "bp", 1)
+	ch.addClient(c)
+
+	ch.SendMessage(SimpleMessage("one"))
+
+	start := time.Now()
+	ch.SendMessage(SimpleMessage("two")) // buffer full, waits out SendTimeout then drops
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	assert.Equal(t, uint64(1), c.Stats().Dropped)
+}
+
+func TestChannelBackpressureDisconnectSlow(t *testing.T) {
+	ch := newChannel("bp", &Options{BackpressurePolicy: DisconnectSlow})
+
+	c := newClient("", "bp", 1)
+	ch.addClient(c)
+
+	ch.SendMessage(SimpleMessage("one"))
+	ch.SendMessage(SimpleMessage("two")) // buffer full, client gets disconnected
+
+	assert.Equal(t, 0, ch.ClientCount())
+	assert.Equal(t, uint64(1), c.Stats().Dropped)
+
+	msg, ok := <-c.send
+	assert.True(t, ok)
+	assert.Equal(t, "one", msg.data)
+
+	_, ok = <-c.send
+	assert.False(t, ok)
+}
+
+func TestServerHeartbeatCadence(t *testing.T) {
+	srv := NewServer(&Options{
+		Logger:            log.New(ioutil.Discard, "", 0),
+		HeartbeatInterval: 20 * time.Millisecond,
+	})
+	defer srv.Shutdown()
+
+	srvtest := httptest.NewServer(srv)
+	defer srvtest.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := ctxhttp.Get(ctx, http.DefaultClient, srvtest.URL+"/news")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	pings := make(chan string, 4)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			if strings.HasPrefix(line, ":") {
+				pings <- line
+			}
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case line := <-pings:
+			assert.Equal(t, ": ping", line)
+		case <-time.After(200 * time.Millisecond):
+			t.Fatal("did not receive heartbeat in time")
+		}
+	}
+}
+
+func TestServerHeartbeatDoesNotRaceWithSendMessage(t *testing.T) {
+	srv := NewServer(&Options{
+		Logger:            log.New(ioutil.Discard, "", 0),
+		HeartbeatInterval: 2 * time.Millisecond,
+	})
+	defer srv.Shutdown()
+
+	srvtest := httptest.NewServer(srv)
+	defer srvtest.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := ctxhttp.Get(ctx, http.DefaultClient, srvtest.URL+"/news")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	lines := make(chan string, 64)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	require.Eventually(t, func() bool { return srv.HasChannel("/news") }, 2*time.Second, 10*time.Millisecond)
+
+	var gotData bool
+	for i := 0; i < 50 && !gotData; i++ {
+		require.NoError(t, srv.SendMessage("/news", SimpleMessage("hello")))
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "data: ") {
+				assert.Equal(t, "data: hello", line)
+				gotData = true
+			}
+		case <-time.After(200 * time.Millisecond):
+			t.Fatal("never received a data line amid heartbeats")
+		}
+	}
+
+	assert.True(t, gotData, "SendMessage output was never observed between heartbeats")
+}
+
+func TestServerClientIdleTimeoutDisconnects(t *testing.T) {
+	srv := NewServer(&Options{
+		Logger:              log.New(ioutil.Discard, "", 0),
+		OnClientIdleTimeout: 20 * time.Millisecond,
+	})
+	defer srv.Shutdown()
+
+	srvtest := httptest.NewServer(srv)
+	defer srvtest.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := ctxhttp.Get(ctx, http.DefaultClient, srvtest.URL+"/news")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Eventually(t, func() bool { return srv.HasChannel("/news") }, 2*time.Second, 10*time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client was never disconnected after going idle")
+	}
+}
+
+func TestServerSendMessageContextCancelled(t *testing.T) {
+	srv := NewServer(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+	defer srv.Shutdown()
+
+	srv.addChannel("news")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := srv.SendMessageContext(ctx, "", SimpleMessage("hello"))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestChannelSendMessageContextStopsBroadcast(t *testing.T) {
+	ch := newChannel("bp", &Options{BackpressurePolicy: Block, SendTimeout: time.Second})
+
+	c := newClient("", "bp", 1)
+	ch.addClient(c)
+
+	ch.SendMessage(SimpleMessage("one")) // fills the single-slot buffer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		// with ctx already done, Block must give up immediately instead of
+		// waiting out its full SendTimeout.
+		ch.SendMessageContext(ctx, SimpleMessage("two"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("SendMessageContext did not bail out once ctx was already done")
+	}
+}
+
+func TestServerStartContextStopsDispatchOnCancel(t *testing.T) {
+	srv := NewServer(&Options{
+		Logger:          log.New(ioutil.Discard, "", 0),
+		DontStartServer: true,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, srv.StartContext(ctx))
+
+	cancel()
+
+	assert.Eventually(t, func() bool { return !srv.hasStarted() }, 2*time.Second, 10*time.Millisecond)
+}